@@ -0,0 +1,425 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/exec"
+)
+
+// Object is an interface that allows obtaining a a list of Objects
+type Object interface {
+	// Live returns the version of the object currently present on the
+	// cluster identified by context. An empty context means the
+	// default/current cluster.
+	Live(context string) runtime.Object
+	Merged() (runtime.Object, error)
+
+	Name() string
+	// Namespace returns the object's namespace, or "" for cluster-scoped
+	// objects. Together with GroupVersionKind and Name, it's what
+	// distinguishes e.g. a ConfigMap/app-config in namespace "staging"
+	// from one in namespace "prod" in Watch's cache.
+	Namespace() string
+	GroupVersionKind() schema.GroupVersionKind
+}
+
+// Printer is used to print an object.
+type Printer struct {
+}
+
+// Print the object inside the writer w.
+func (p *Printer) Print(obj runtime.Object, w io.Writer) error {
+	if obj == nil {
+		return nil
+	}
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Differ creates two DiffVersion and diffs them.
+type Differ struct {
+	From *DiffVersion
+	To   *DiffVersion
+
+	// Contexts holds the cluster contexts to diff the manifests against.
+	// The command layer (NewCmdDiff/Options, not present in this package)
+	// is expected to populate this from a --contexts flag. When empty,
+	// Diff runs a plain single-cluster diff with the original flat
+	// <dir>/<name> layout. When set, every context gets its own
+	// <dir>/<context>/<name> subtree, so that a single recursive external
+	// diff shows drift across every cluster at once.
+	Contexts []string
+
+	// SecretDecoders maps a decoderAnnotation value to the SecretDecoder
+	// that decrypts a merged Secret's "data" before it's compared and
+	// masked. Defaults to defaultSecretDecoders when nil.
+	SecretDecoders map[string]SecretDecoder
+
+	// IgnorePaths lists additional fields, beyond defaultIgnorePaths, to
+	// strip from both sides before they are compared and masked. The
+	// command layer (not present in this package) is expected to
+	// populate this from a repeatable --ignore-path flag and a
+	// .kubectldiffignore file, in addition to whatever defaultIgnorePaths
+	// already covers.
+	IgnorePaths []IgnorePath
+
+	// Policy, when set, gates each object's diff in every cluster context:
+	// Diff returns an error when the policy reports a violation. The
+	// command layer is expected to populate this from a --policy-bundle
+	// flag (typically a RegoPolicyEvaluator) and fail the command when any
+	// object's PolicyResult is not Allowed. A nil Policy means no gating.
+	Policy PolicyEvaluator
+}
+
+// NewDiffer creates a new Differ. contexts is optional; pass none to diff
+// a single cluster with the original flat directory layout.
+func NewDiffer(from, to string, contexts ...string) (*Differ, error) {
+	from2, err := NewDiffVersion(from)
+	if err != nil {
+		return nil, err
+	}
+	to2, err := NewDiffVersion(to)
+	if err != nil {
+		from2.Dir.Delete()
+		return nil, err
+	}
+	return &Differ{
+		From:     from2,
+		To:       to2,
+		Contexts: contexts,
+	}, nil
+}
+
+// Diff diffs to versions of a specific object, and print both versions to directories.
+func (d *Differ) Diff(ctx context.Context, obj Object, printer Printer) error {
+	decoders := d.SecretDecoders
+	if decoders == nil {
+		decoders = defaultSecretDecoders
+	}
+	masker := Masker{IgnorePaths: d.IgnorePaths}
+
+	contexts := d.Contexts
+	if len(contexts) == 0 {
+		contexts = []string{""}
+	}
+	for _, clusterContext := range contexts {
+		from, err := d.From.getObject(obj, clusterContext)
+		if err != nil {
+			return err
+		}
+		to, err := d.To.getObject(obj, clusterContext)
+		if err != nil {
+			return err
+		}
+
+		// Decrypt the merged side, if it's an encrypted Secret manifest,
+		// before masking: the file DiffVersion.Print writes out ends up
+		// decrypted-then-masked, even though the decode itself has to
+		// happen here (mask needs the plaintext from both sides at once,
+		// which Print's single-object signature can't provide).
+		to, err = decryptMerged(to, decoders)
+		if err != nil {
+			return err
+		}
+
+		// Drop churny, apiserver-managed fields before comparing, so that
+		// e.g. a bumped resourceVersion doesn't show up as drift.
+		from, err = masker.Filter(from)
+		if err != nil {
+			return err
+		}
+		to, err = masker.Filter(to)
+		if err != nil {
+			return err
+		}
+
+		from, to, err = mask(from, to)
+		if err != nil {
+			return err
+		}
+
+		if d.Policy != nil {
+			fromData, err := toMap(from)
+			if err != nil {
+				return err
+			}
+			toData, err := toMap(to)
+			if err != nil {
+				return err
+			}
+			result, err := d.checkPolicy(ctx, fromData, toData, buildObjectDiff(obj, fromData, toData))
+			if err != nil {
+				return err
+			}
+			if !result.Allowed {
+				return fmt.Errorf("policy violation for %s %s/%s: %s", obj.GroupVersionKind().Kind, obj.Namespace(), obj.Name(), strings.Join(result.Violations, "; "))
+			}
+		}
+
+		name := obj.Name()
+		if clusterContext != "" {
+			name = filepath.Join(clusterContext, name)
+		}
+		if err := d.From.Print(name, from, printer); err != nil {
+			return err
+		}
+		if err := d.To.Print(name, to, printer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TearDown removes both temporary directories
+func (d *Differ) TearDown() {
+	d.From.Dir.Delete()
+	d.To.Dir.Delete()
+}
+
+// DiffProgram finds and run the diff program for kubectl diff.
+type DiffProgram struct {
+	Exec exec.Interface
+	genericclioptions.IOStreams
+
+	// Contexts mirrors Differ.Contexts; the command layer is expected to
+	// set both from the same --contexts flag value. When set, the
+	// default diff invocation recurses into the per-context directories
+	// that Differ lays out, so drift across every cluster shows up in a
+	// single diff.
+	Contexts []string
+}
+
+// getCommand is the function that retrieves the diff command.
+func (d *DiffProgram) getCommand(args ...string) exec.Cmd {
+	diff := ""
+	if envDiff := os.Getenv("KUBECTL_EXTERNAL_DIFF"); envDiff != "" {
+		diff = envDiff
+	} else {
+		diff = "diff"
+		defaultArgs := []string{"-u", "-N"}
+		if len(d.Contexts) > 0 {
+			defaultArgs = append(defaultArgs, "-r")
+		}
+		args = append(defaultArgs, args...)
+	}
+
+	cmdStr, args := splitCommandLine(diff, args)
+	cmd := d.Exec.Command(cmdStr, args...)
+	cmd.SetStdout(d.Out)
+	cmd.SetStderr(d.ErrOut)
+	cmd.SetStdin(d.In)
+
+	return cmd
+}
+
+// splitCommandLine splits the diff program string into a command name
+// and a final set of arguments, appending the original args to whatever
+// arguments were already present on the configured command line.
+func splitCommandLine(diffCommand string, args []string) (string, []string) {
+	components := strings.Fields(diffCommand)
+	if len(components) == 0 {
+		return diffCommand, args
+	}
+	return components[0], append(components[1:], args...)
+}
+
+// Run runs the diff program.
+func (d *DiffProgram) Run(from, to string) error {
+	cmd := d.getCommand(from, to)
+	if err := cmd.Run(); err != nil {
+		// Let's not wrap diff exit-code errors, as diffs will originate
+		// exit code 1, which we don't want to swallow.
+		return err
+	}
+	return nil
+}
+
+// Directory creates a new temp directory, and allows to easily create new
+// files inside it.
+type Directory struct {
+	Name string
+}
+
+// CreateDirectory does create the actual disk directory, and return a
+// new representation of it.
+func CreateDirectory(prefix string) (*Directory, error) {
+	name, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Directory{
+		Name: name,
+	}, nil
+}
+
+// NewFile creates a new file in the directory. name may contain slashes,
+// in which case the intermediate directories (e.g. a cluster context) are
+// created as needed.
+func (d *Directory) NewFile(name string) (*os.File, error) {
+	path := filepath.Join(d.Name, name)
+	if dir := filepath.Dir(path); dir != d.Name {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0755)
+}
+
+// Delete removes the directory recursively.
+func (d *Directory) Delete() error {
+	return os.RemoveAll(d.Name)
+}
+
+// DiffVersion gets the proper version of objects, and aggregate them into a directory.
+type DiffVersion struct {
+	Dir  *Directory
+	Name string
+}
+
+// NewDiffVersion creates a new DiffVersion with the provided name, and
+// creates the directory.
+func NewDiffVersion(name string) (*DiffVersion, error) {
+	dir, err := CreateDirectory(name)
+	if err != nil {
+		return nil, err
+	}
+	return &DiffVersion{
+		Dir:  dir,
+		Name: name,
+	}, nil
+}
+
+func (v *DiffVersion) getObject(obj Object, context string) (runtime.Object, error) {
+	switch v.Name {
+	case "LIVE":
+		return obj.Live(context), nil
+	case "MERGED":
+		return obj.Merged()
+	}
+	return nil, fmt.Errorf("Invalid version: %s", v.Name)
+}
+
+// Print prints the object using the printer into a new file in the directory.
+func (v *DiffVersion) Print(name string, obj runtime.Object, printer Printer) error {
+	f, err := v.Dir.NewFile(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return printer.Print(obj, f)
+}
+
+// mask replaces every value under the "data" field of the objects being
+// diffed with placeholder values, so that Secret contents are never written
+// to disk or shown in a diff. When a value changes, the masked values get
+// "(before)" and "(after)" suffixes, so the diff still shows that something
+// changed without revealing what.
+func mask(from, to runtime.Object) (runtime.Object, runtime.Object, error) {
+	// mask replaces the "data" field of the objects it masks. Deep-copy
+	// first so the caller's objects (e.g. the Merged() object that every
+	// cluster context diffs against) never lose the plaintext.
+	if from != nil {
+		from = from.DeepCopyObject()
+	}
+	if to != nil {
+		to = to.DeepCopyObject()
+	}
+
+	fromUnstructured, fromData, err := unstructuredNestedMap(from, "data")
+	if err != nil {
+		return nil, nil, err
+	}
+	toUnstructured, toData, err := unstructuredNestedMap(to, "data")
+	if err != nil {
+		return nil, nil, err
+	}
+	if fromData == nil && toData == nil {
+		// Neither have a "data" field; nothing to mask.
+		return from, to, nil
+	}
+
+	newFromData := map[string]interface{}{}
+	newToData := map[string]interface{}{}
+	for k := range fromData {
+		if _, ok := toData[k]; ok {
+			if reflect.DeepEqual(fromData[k], toData[k]) {
+				newFromData[k] = "***"
+				newToData[k] = "***"
+			} else {
+				newFromData[k] = "*** (before)"
+				newToData[k] = "*** (after)"
+			}
+		} else {
+			newFromData[k] = "***"
+		}
+	}
+	for k := range toData {
+		if _, ok := fromData[k]; !ok {
+			newToData[k] = "***"
+		}
+	}
+
+	if fromUnstructured != nil {
+		fromUnstructured.Object["data"] = newFromData
+	}
+	if toUnstructured != nil {
+		toUnstructured.Object["data"] = newToData
+	}
+	return from, to, nil
+}
+
+// unstructuredNestedMap returns the Unstructured representation of obj
+// along with the nested map found at the given fields, if any.
+func unstructuredNestedMap(obj runtime.Object, fields ...string) (*unstructured.Unstructured, map[string]interface{}, error) {
+	if obj == nil || len(fields) == 0 {
+		return nil, nil, nil
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		klog.V(4).Infof("object %T is not unstructured, skipping", obj)
+		return nil, nil, nil
+	}
+	data, found, err := unstructured.NestedMap(u.Object, fields...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !found {
+		return u, nil, nil
+	}
+	return u, data, nil
+}
@@ -18,6 +18,7 @@ package diff
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
 	"path"
@@ -34,12 +35,17 @@ import (
 )
 
 type FakeObject struct {
-	name    string
-	group   string
-	version string
-	kind    string
-	merged  map[string]interface{}
-	live    map[string]interface{}
+	name      string
+	namespace string
+	group     string
+	version   string
+	kind      string
+	merged    map[string]interface{}
+	live      map[string]interface{}
+	// liveInContext holds additional live versions of the object, keyed
+	// by cluster context, for exercising the multi-cluster fan-out in
+	// Differ.
+	liveInContext map[string]map[string]interface{}
 }
 
 var _ Object = &FakeObject{}
@@ -48,6 +54,10 @@ func (f *FakeObject) Name() string {
 	return f.name
 }
 
+func (f *FakeObject) Namespace() string {
+	return f.namespace
+}
+
 func (f *FakeObject) GroupVersionKind() schema.GroupVersionKind {
 	return schema.GroupVersionKind{
 		Group:   f.group,
@@ -64,7 +74,15 @@ func (f *FakeObject) Merged() (runtime.Object, error) {
 	return &unstructured.Unstructured{Object: f.merged}, nil
 }
 
-func (f *FakeObject) Live() runtime.Object {
+func (f *FakeObject) Live(context string) runtime.Object {
+	if context != "" {
+		// Return nil if this context has no live object
+		live, ok := f.liveInContext[context]
+		if !ok {
+			return nil
+		}
+		return &unstructured.Unstructured{Object: live}
+	}
 	// Return nil if live object does not exist
 	if f.live == nil {
 		return nil
@@ -211,7 +229,7 @@ func TestDiffer(t *testing.T) {
 		live:   map[string]interface{}{"live": true},
 		merged: map[string]interface{}{"merged": true},
 	}
-	err = diff.Diff(&obj, Printer{})
+	err = diff.Diff(context.Background(), &obj, Printer{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -234,6 +252,89 @@ func TestDiffer(t *testing.T) {
 	}
 }
 
+func TestDifferMultiCluster(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED", "staging", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	obj := FakeObject{
+		name:   "bla",
+		merged: map[string]interface{}{"merged": true},
+		liveInContext: map[string]map[string]interface{}{
+			"staging": {"live": "staging"},
+			"prod":    {"live": "prod"},
+		},
+	}
+	err = diff.Diff(context.Background(), &obj, Printer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		context  string
+		econtent string
+	}{
+		{"staging", "live: staging\n"},
+		{"prod", "live: prod\n"},
+	} {
+		fcontent, err := ioutil.ReadFile(path.Join(diff.From.Dir.Name, tc.context, obj.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fcontent) != tc.econtent {
+			t.Fatalf("File has %q, expected %q", string(fcontent), tc.econtent)
+		}
+
+		fcontent, err = ioutil.ReadFile(path.Join(diff.To.Dir.Name, tc.context, obj.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		econtent := "merged: true\n"
+		if string(fcontent) != econtent {
+			t.Fatalf("File has %q, expected %q", string(fcontent), econtent)
+		}
+	}
+}
+
+func TestDifferMultiClusterMasksDataPerContext(t *testing.T) {
+	// Regression test: mask() used to mutate the Merged() object's "data"
+	// field in place, and Diff calls mask once per context against the
+	// very same Merged() object. A context whose live data happened to
+	// differ from the plaintext merged data would permanently clobber
+	// that plaintext with "*** (after)" placeholders, corrupting the
+	// equality check for every context diffed afterwards.
+	diff, err := NewDiffer("LIVE", "MERGED", "first", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	obj := FakeObject{
+		name: "bla",
+		merged: map[string]interface{}{
+			"data": map[string]interface{}{"password": "123"},
+		},
+		liveInContext: map[string]map[string]interface{}{
+			"first":  {"data": map[string]interface{}{"password": "999"}}, // differs
+			"second": {"data": map[string]interface{}{"password": "123"}}, // matches
+		},
+	}
+	if err := diff.Diff(context.Background(), &obj, Printer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fcontent, err := ioutil.ReadFile(path.Join(diff.To.Dir.Name, "second", obj.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "data:\n  password: '***'\n"
+	if string(fcontent) != want {
+		t.Fatalf("second context merged file = %q, want %q (plaintext must not leak the first context's masking)", string(fcontent), want)
+	}
+}
+
 func TestMask(t *testing.T) {
 	type diff struct {
 		from runtime.Object
@@ -0,0 +1,167 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// IgnorePath is a JSON Pointer (RFC 6901) identifying a field to drop from
+// both the live and merged objects before they are compared, e.g.
+// "/metadata/generation" or "/spec/template/*/image". This is the same
+// addressing convention as FieldChange.Path elsewhere in this package, with
+// one deliberate extension: a literal "*" reference token matches every
+// element of an array, since RFC 6901 has no array wildcard and a concrete
+// index isn't stable enough across live/merged objects to be worth matching
+// on.
+type IgnorePath string
+
+// defaultIgnorePaths lists fields the apiserver or controllers mutate on
+// every read/write that don't represent a user-intended difference, and so
+// are always ignored in addition to whatever the user configures via
+// Differ.IgnorePaths.
+var defaultIgnorePaths = []IgnorePath{
+	"/metadata/resourceVersion",
+	"/metadata/generation",
+	"/metadata/managedFields",
+	"/metadata/creationTimestamp",
+	"/status",
+}
+
+// Masker removes a set of IgnorePaths from an object before Diff compares
+// and (for Secrets) masks it. A zero-value Masker only applies
+// defaultIgnorePaths.
+type Masker struct {
+	// IgnorePaths are applied in addition to defaultIgnorePaths.
+	IgnorePaths []IgnorePath
+}
+
+// Filter returns a copy of obj with every configured IgnorePath removed.
+// Non-Unstructured objects are returned unchanged, since there is no
+// generic way to address a field path on them.
+func (m Masker) Filter(obj runtime.Object) (runtime.Object, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	if _, ok := obj.(*unstructured.Unstructured); !ok {
+		return obj, nil
+	}
+
+	out := obj.DeepCopyObject().(*unstructured.Unstructured)
+	for _, p := range defaultIgnorePaths {
+		removeIgnorePath(out.Object, splitJSONPointer(p))
+	}
+	for _, p := range m.IgnorePaths {
+		removeIgnorePath(out.Object, splitJSONPointer(p))
+	}
+	return out, nil
+}
+
+// splitJSONPointer splits a JSON Pointer into its unescaped reference
+// tokens, e.g. "/spec/template/*/image" into ["spec", "template", "*",
+// "image"]. The leading "/" (and the empty root pointer "") produce no
+// leading empty token.
+func splitJSONPointer(p IgnorePath) []string {
+	s := strings.TrimPrefix(string(p), "/")
+	if s == "" {
+		return nil
+	}
+	segments := strings.Split(s, "/")
+	for i, seg := range segments {
+		segments[i] = unescapeJSONPointerToken(seg)
+	}
+	return segments
+}
+
+// unescapeJSONPointerToken reverses escapeJSONPointerToken's RFC 6901
+// escaping of "~" and "/" within a single reference token.
+func unescapeJSONPointerToken(token string) string {
+	token = strings.ReplaceAll(token, "~1", "/")
+	token = strings.ReplaceAll(token, "~0", "~")
+	return token
+}
+
+// removeIgnorePath deletes the field addressed by segments from obj,
+// descending through nested maps and, for a "*" segment, every map
+// element of a slice.
+func removeIgnorePath(obj map[string]interface{}, segments []string) {
+	if len(segments) == 0 {
+		return
+	}
+	key := segments[0]
+	if len(segments) == 1 {
+		delete(obj, key)
+		return
+	}
+	switch v := obj[key].(type) {
+	case map[string]interface{}:
+		removeIgnorePath(v, segments[1:])
+	case []interface{}:
+		removeIgnorePathFromSlice(v, segments[1:])
+	}
+}
+
+func removeIgnorePathFromSlice(items []interface{}, segments []string) {
+	if len(segments) == 0 || segments[0] != "*" {
+		// Only the "*" wildcard is supported for array elements; a
+		// concrete index isn't stable enough across live/merged objects
+		// to be worth matching on.
+		return
+	}
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			removeIgnorePath(m, segments[1:])
+		}
+	}
+}
+
+// LoadIgnoreFile reads newline-separated ignore paths from name (normally
+// a .kubectldiffignore file in the working directory), skipping blank
+// lines and lines starting with "#". It returns (nil, nil) if name does
+// not exist, so callers can unconditionally look for the default ignore
+// file without checking for its existence first.
+func LoadIgnoreFile(name string) ([]IgnorePath, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseIgnorePaths(bytes.NewReader(data))
+}
+
+func parseIgnorePaths(r io.Reader) ([]IgnorePath, error) {
+	var paths []IgnorePath
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, IgnorePath(line))
+	}
+	return paths, scanner.Err()
+}
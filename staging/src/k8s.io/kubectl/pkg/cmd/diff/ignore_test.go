@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMaskerFilterDefaults(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":              "bla",
+			"resourceVersion":   "42",
+			"generation":        int64(3),
+			"creationTimestamp": "2020-01-01T00:00:00Z",
+			"managedFields":     []interface{}{map[string]interface{}{"manager": "kubectl"}},
+		},
+		"status": map[string]interface{}{"replicas": int64(1)},
+		"spec":   map[string]interface{}{"replicas": int64(1)},
+	}}
+
+	got, err := Masker{}.Filter(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "bla"},
+		"spec":     map[string]interface{}{"replicas": int64(1)},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	// The original object must be untouched.
+	if _, ok := obj.Object["status"]; !ok {
+		t.Error("Filter mutated the input object in place")
+	}
+}
+
+func TestMaskerFilterNestedPath(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"annotations": map[string]interface{}{"a": "b"}},
+			},
+		},
+	}}
+	masker := Masker{IgnorePaths: []IgnorePath{"/spec/template/metadata"}}
+	got, err := masker.Filter(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"template": map[string]interface{}{}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestMaskerFilterArrayWildcard(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "a", "image": "a:1"},
+				map[string]interface{}{"name": "b", "image": "b:1"},
+			},
+		},
+	}}
+	masker := Masker{IgnorePaths: []IgnorePath{"/spec/containers/*/image"}}
+	got, err := masker.Filter(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "a"},
+				map[string]interface{}{"name": "b"},
+			},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestLoadIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, ".kubectldiffignore")
+	content := "# comment\n\n/metadata/labels/injected\n  /spec/replicas  \n"
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadIgnoreFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IgnorePath{"/metadata/labels/injected", "/spec/replicas"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadIgnoreFileMissing(t *testing.T) {
+	got, err := LoadIgnoreFile(filepath.Join(t.TempDir(), "does-not-exist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Errorf("got %v, want nil for a missing ignore file", got)
+	}
+}
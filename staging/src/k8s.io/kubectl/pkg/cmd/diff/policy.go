@@ -0,0 +1,151 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// PolicyInput is what gets evaluated against a bundle of Rego policies:
+// the raw live and merged object data, plus the structured diff already
+// computed for it.
+type PolicyInput struct {
+	Live   map[string]interface{} `json:"live"`
+	Merged map[string]interface{} `json:"merged"`
+	Diff   *ObjectDiff            `json:"diff,omitempty"`
+}
+
+// PolicyResult is the outcome of evaluating one object's PolicyInput,
+// used to decide whether the diff should fail. It is returned by
+// CheckPolicy; ObjectDiff and StructuredPrinter's output do not include it.
+type PolicyResult struct {
+	Allowed    bool     `json:"allowed"`
+	Violations []string `json:"violations,omitempty"`
+}
+
+// PolicyEvaluator evaluates a single object's PolicyInput against a
+// compiled policy bundle and reports any violations, e.g. "reject diffs
+// that change a container image tag to :latest".
+type PolicyEvaluator interface {
+	Evaluate(ctx context.Context, input PolicyInput) (PolicyResult, error)
+}
+
+// defaultPolicyQuery is the Rego rule RegoPolicyEvaluator evaluates:
+// policies contribute violation messages by adding to the "deny" set in
+// the "kubectldiff" package.
+const defaultPolicyQuery = "data.kubectldiff.deny"
+
+// RegoPolicyEvaluator evaluates PolicyInput against a bundle of Rego
+// policies compiled once via the OPA Go SDK, reusing the resulting
+// PreparedEvalQuery across every Evaluate call rather than recompiling
+// the bundle per object.
+type RegoPolicyEvaluator struct {
+	query rego.PreparedEvalQuery
+}
+
+// NewRegoPolicyEvaluator loads and compiles the policy bundle at
+// bundlePath (a directory or tarball, as given to --policy-bundle),
+// evaluating defaultPolicyQuery against it.
+func NewRegoPolicyEvaluator(ctx context.Context, bundlePath string) (*RegoPolicyEvaluator, error) {
+	return NewRegoPolicyEvaluatorWithQuery(ctx, bundlePath, defaultPolicyQuery)
+}
+
+// NewRegoPolicyEvaluatorWithQuery is like NewRegoPolicyEvaluator, but lets
+// the caller evaluate a Rego rule other than defaultPolicyQuery.
+func NewRegoPolicyEvaluatorWithQuery(ctx context.Context, bundlePath, query string) (*RegoPolicyEvaluator, error) {
+	if query == "" {
+		query = defaultPolicyQuery
+	}
+	prepared, err := rego.New(
+		rego.Query(query),
+		rego.LoadBundle(bundlePath),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("compiling policy bundle %q: %w", bundlePath, err)
+	}
+	return &RegoPolicyEvaluator{query: prepared}, nil
+}
+
+// Evaluate implements PolicyEvaluator.
+func (e *RegoPolicyEvaluator) Evaluate(ctx context.Context, input PolicyInput) (PolicyResult, error) {
+	// rego.EvalInput needs plain JSON-compatible values, not a typed
+	// struct, so round-trip PolicyInput through JSON first.
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return PolicyResult{}, err
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return PolicyResult{}, err
+	}
+
+	rs, err := e.query.Eval(ctx, rego.EvalInput(decoded))
+	if err != nil {
+		return PolicyResult{}, fmt.Errorf("evaluating policy: %w", err)
+	}
+	violations := extractViolations(rs)
+	return PolicyResult{Allowed: len(violations) == 0, Violations: violations}, nil
+}
+
+// extractViolations collects every string in a Rego "deny" set (or single
+// string rule) out of the evaluation's ResultSet.
+func extractViolations(rs rego.ResultSet) []string {
+	var violations []string
+	for _, result := range rs {
+		for _, expr := range result.Expressions {
+			switch v := expr.Value.(type) {
+			case []interface{}:
+				for _, item := range v {
+					if s, ok := item.(string); ok {
+						violations = append(violations, s)
+					}
+				}
+			case string:
+				violations = append(violations, v)
+			}
+		}
+	}
+	return violations
+}
+
+// CheckPolicy evaluates obj's live/merged/diff against d.Policy, the
+// PolicyEvaluator selected by --policy-bundle. It reports
+// PolicyResult{Allowed: true} when d.Policy is nil, so callers don't need
+// to special-case the "no policy configured" case themselves. This
+// recomputes obj's default-context diff; Diff itself calls checkPolicy
+// directly with the per-context diff it already has, to gate every
+// cluster context rather than just the default one.
+func (d *Differ) CheckPolicy(ctx context.Context, obj Object) (PolicyResult, error) {
+	diff, fromData, toData, err := d.diffStructured(obj)
+	if err != nil {
+		return PolicyResult{}, err
+	}
+	return d.checkPolicy(ctx, fromData, toData, diff)
+}
+
+// checkPolicy evaluates an already-computed diff against d.Policy.
+func (d *Differ) checkPolicy(ctx context.Context, fromData, toData map[string]interface{}, diff *ObjectDiff) (PolicyResult, error) {
+	if d.Policy == nil {
+		return PolicyResult{Allowed: true}, nil
+	}
+	input := PolicyInput{Live: fromData, Merged: toData, Diff: diff}
+	return d.Policy.Evaluate(ctx, input)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"context"
+	"testing"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// fakePolicy stands in for a compiled Rego policy: it denies an object
+// whenever any FieldChange in its diff lands on a path in denyPaths,
+// mirroring a rule like "reject diffs that change spec.image to :latest".
+type fakePolicy struct {
+	denyPaths map[string]bool
+}
+
+func (p fakePolicy) Evaluate(_ context.Context, input PolicyInput) (PolicyResult, error) {
+	if input.Diff == nil {
+		return PolicyResult{Allowed: true}, nil
+	}
+	var violations []string
+	for _, change := range input.Diff.Changes {
+		if p.denyPaths[change.Path] {
+			violations = append(violations, "field "+change.Path+" is not allowed to change")
+		}
+	}
+	return PolicyResult{Allowed: len(violations) == 0, Violations: violations}, nil
+}
+
+func TestDifferPolicyAllow(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+	diff.Policy = fakePolicy{denyPaths: map[string]bool{"/spec/image": true}}
+
+	obj := FakeObject{
+		name:   "bla",
+		live:   map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}},
+		merged: map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}},
+	}
+
+	result, err := diff.CheckPolicy(context.Background(), &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed {
+		t.Errorf("got %+v, want an allowed result for a change the policy doesn't deny", result)
+	}
+}
+
+func TestDifferPolicyDeny(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+	diff.Policy = fakePolicy{denyPaths: map[string]bool{"/spec/image": true}}
+
+	obj := FakeObject{
+		name:   "bla",
+		live:   map[string]interface{}{"spec": map[string]interface{}{"image": "app:v1"}},
+		merged: map[string]interface{}{"spec": map[string]interface{}{"image": "app:latest"}},
+	}
+
+	result, err := diff.CheckPolicy(context.Background(), &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatal("got an allowed result, want the policy to deny changing spec.image")
+	}
+	if len(result.Violations) != 1 {
+		t.Errorf("got violations %v, want exactly one", result.Violations)
+	}
+}
+
+// TestDifferDiffDeniesOnPolicyViolation proves Diff itself gates on
+// d.Policy, the way it already applies SecretDecoders and IgnorePaths,
+// rather than leaving CheckPolicy as a stage nothing calls.
+func TestDifferDiffDeniesOnPolicyViolation(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+	diff.Policy = fakePolicy{denyPaths: map[string]bool{"/spec/image": true}}
+
+	obj := FakeObject{
+		name: "bla",
+		live: map[string]interface{}{
+			"spec": map[string]interface{}{"image": "app:v1"},
+		},
+		merged: map[string]interface{}{
+			"spec": map[string]interface{}{"image": "app:latest"},
+		},
+	}
+	if err := diff.Diff(context.Background(), &obj, Printer{}); err == nil {
+		t.Fatal("expected Diff to return an error for a policy violation, got nil")
+	}
+}
+
+// TestDifferDiffAllowsWithoutViolation proves a non-denied diff still gets
+// printed when a Policy is configured.
+func TestDifferDiffAllowsWithoutViolation(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+	diff.Policy = fakePolicy{denyPaths: map[string]bool{"/spec/image": true}}
+
+	obj := FakeObject{
+		name: "bla",
+		live: map[string]interface{}{
+			"spec": map[string]interface{}{"replicas": int64(1)},
+		},
+		merged: map[string]interface{}{
+			"spec": map[string]interface{}{"replicas": int64(3)},
+		},
+	}
+	if err := diff.Diff(context.Background(), &obj, Printer{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRegoPolicyEvaluator exercises the real OPA Go SDK path, proving that
+// RegoPolicyEvaluator can evaluate a prepared query, compiled once,
+// repeatedly. It compiles an inline module via rego.Module
+// instead of going through NewRegoPolicyEvaluatorWithQuery's
+// rego.LoadBundle, since that expects an on-disk bundle directory.
+func TestRegoPolicyEvaluator(t *testing.T) {
+	const module = `
+package kubectldiff
+
+deny[msg] {
+	input.diff.changes[_].path == "/spec/image"
+	msg := "changing spec.image is not allowed"
+}
+`
+	ctx := context.Background()
+	prepared, err := rego.New(
+		rego.Query(defaultPolicyQuery),
+		rego.Module("policy_test.rego", module),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evaluator := &RegoPolicyEvaluator{query: prepared}
+
+	allowed := PolicyInput{Diff: &ObjectDiff{Changes: []FieldChange{{Path: "/spec/replicas"}}}}
+	result, err := evaluator.Evaluate(ctx, allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed {
+		t.Errorf("got %+v, want an allowed result for a change the policy doesn't deny", result)
+	}
+
+	denied := PolicyInput{Diff: &ObjectDiff{Changes: []FieldChange{{Path: "/spec/image"}}}}
+	result, err = evaluator.Evaluate(ctx, denied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatal("got an allowed result, want the policy to deny changing spec.image")
+	}
+	if len(result.Violations) != 1 {
+		t.Errorf("got violations %v, want exactly one", result.Violations)
+	}
+
+	// The same prepared query is reused across calls rather than
+	// recompiling the module each time.
+	result, err = evaluator.Evaluate(ctx, allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed {
+		t.Errorf("got %+v, want an allowed result on a repeated evaluation", result)
+	}
+}
+
+func TestDifferPolicyNilIsAllowed(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	obj := FakeObject{
+		name:   "bla",
+		live:   map[string]interface{}{"spec": "a"},
+		merged: map[string]interface{}{"spec": "b"},
+	}
+	result, err := diff.CheckPolicy(context.Background(), &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed {
+		t.Errorf("got %+v, want Allowed when Differ.Policy is nil", result)
+	}
+}
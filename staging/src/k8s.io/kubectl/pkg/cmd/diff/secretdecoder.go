@@ -0,0 +1,197 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/exec"
+)
+
+// decoderAnnotation selects which SecretDecoder decrypts a merged
+// manifest's "data" before it is compared (and masked) against the live
+// Secret, e.g. "diff.kubectl.kubernetes.io/decoder: sops".
+const decoderAnnotation = "diff.kubectl.kubernetes.io/decoder"
+
+// SecretDecoder decrypts the "data" payload of an encrypted Secret
+// manifest so it can be compared, field-by-field, against the live,
+// already-decrypted Secret before masking.
+type SecretDecoder interface {
+	Decode(data map[string]interface{}) (map[string]interface{}, error)
+}
+
+// defaultSecretDecoders maps decoderAnnotation values to the SecretDecoder
+// that handles them. A missing or empty annotation uses PlaintextDecoder.
+var defaultSecretDecoders = map[string]SecretDecoder{
+	"":               PlaintextDecoder{},
+	"plaintext":      PlaintextDecoder{},
+	"sops":           SOPSDecoder{Exec: exec.New()},
+	"sealed-secrets": SealedSecretsDecoder{},
+}
+
+// PlaintextDecoder is the default SecretDecoder: the data is already
+// plaintext, so Decode is a no-op.
+type PlaintextDecoder struct{}
+
+// Decode implements SecretDecoder.
+func (PlaintextDecoder) Decode(data map[string]interface{}) (map[string]interface{}, error) {
+	return data, nil
+}
+
+// SOPSDecoder decrypts data encrypted with Mozilla SOPS by shelling out to
+// the sops binary, the same way DiffProgram shells out to an external
+// diff: sops itself resolves whatever KMS/PGP/age key is configured in the
+// environment, so no key material needs to be threaded through here.
+type SOPSDecoder struct {
+	Exec exec.Interface
+}
+
+// Decode implements SecretDecoder.
+func (d SOPSDecoder) Decode(data map[string]interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := d.Exec.Command("sops", "--input-type", "json", "--output-type", "json", "--decrypt", "/dev/stdin")
+	cmd.SetStdin(bytes.NewReader(raw))
+	var out bytes.Buffer
+	cmd.SetStdout(&out)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sops decrypt: %w", err)
+	}
+
+	var decoded struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		return nil, fmt.Errorf("sops decrypt: parsing output: %w", err)
+	}
+	return decoded.Data, nil
+}
+
+// SealedSecretsDecoder decrypts Bitnami sealed-secrets values using the
+// cluster's RSA unsealing key. Unsealing is the whole point of
+// sealed-secrets being asymmetric: without PrivateKey (e.g. extracted from
+// the controller for disaster-recovery, as with `kubeseal
+// --recovery-unseal`), Decode can only fail loudly rather than pretend to
+// succeed.
+type SealedSecretsDecoder struct {
+	PrivateKey *rsa.PrivateKey
+}
+
+// Decode implements SecretDecoder.
+func (d SealedSecretsDecoder) Decode(data map[string]interface{}) (map[string]interface{}, error) {
+	if d.PrivateKey == nil {
+		return nil, fmt.Errorf("sealed-secrets decoder requires the cluster's unsealing private key")
+	}
+	decoded := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("sealed-secrets data[%q] is not a string", k)
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("sealed-secrets data[%q]: %w", k, err)
+		}
+		plaintext, err := hybridDecrypt(d.PrivateKey, ciphertext, []byte(k))
+		if err != nil {
+			return nil, fmt.Errorf("sealed-secrets data[%q]: %w", k, err)
+		}
+		decoded[k] = string(plaintext)
+	}
+	return decoded, nil
+}
+
+// hybridDecrypt reverses sealed-secrets' hybrid encryption scheme: a
+// 2-byte big-endian length, an RSA-OAEP encrypted AES session key, and an
+// AES-GCM encrypted payload keyed by that session key with label as
+// additional authenticated data.
+func hybridDecrypt(key *rsa.PrivateKey, ciphertext, label []byte) ([]byte, error) {
+	if len(ciphertext) < 2 {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	keyLen := int(binary.BigEndian.Uint16(ciphertext))
+	if len(ciphertext) < 2+keyLen {
+		return nil, fmt.Errorf("ciphertext too short for session key")
+	}
+	sessionKeyCiphertext := ciphertext[2 : 2+keyLen]
+	rest := ciphertext[2+keyLen:]
+
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, sessionKeyCiphertext, label)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting session key: %w", err)
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short for nonce")
+	}
+	nonce, encrypted := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, encrypted, label)
+}
+
+// decryptMerged decodes the merged object's "data" field using the
+// SecretDecoder selected by decoderAnnotation, returning obj unchanged if
+// it has no "data" field. decoders is normally Differ.SecretDecoders (or
+// defaultSecretDecoders when unset).
+func decryptMerged(obj runtime.Object, decoders map[string]SecretDecoder) (runtime.Object, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return obj, nil
+	}
+	data, found, err := unstructured.NestedMap(u.Object, "data")
+	if err != nil || !found {
+		return obj, err
+	}
+
+	name, _, err := unstructured.NestedString(u.Object, "metadata", "annotations", decoderAnnotation)
+	if err != nil {
+		return nil, err
+	}
+	decoder, ok := decoders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown %s decoder: %q", decoderAnnotation, name)
+	}
+
+	decoded, err := decoder.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	out := obj.DeepCopyObject().(*unstructured.Unstructured)
+	out.Object["data"] = decoded
+	return out, nil
+}
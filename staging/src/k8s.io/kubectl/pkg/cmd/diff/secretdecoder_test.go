@@ -0,0 +1,193 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// hybridEncryptForTest is the inverse of hybridDecrypt, used only to
+// produce fixtures for TestSealedSecretsDecoderRoundTrip without needing a
+// real sealed-secrets ciphertext on disk.
+func hybridEncryptForTest(pub *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	encryptedSessionKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	encrypted := gcm.Seal(nonce, nonce, plaintext, label)
+
+	out := make([]byte, 2, 2+len(encryptedSessionKey)+len(encrypted))
+	binary.BigEndian.PutUint16(out, uint16(len(encryptedSessionKey)))
+	out = append(out, encryptedSessionKey...)
+	out = append(out, encrypted...)
+	return out, nil
+}
+
+// reverseDecoder is a trivial stand-in SecretDecoder for tests: it
+// "decrypts" a value by reversing it, so ciphertext differs from
+// plaintext in an easily verifiable way without needing a real cipher.
+type reverseDecoder struct{}
+
+func (reverseDecoder) Decode(data map[string]interface{}) (map[string]interface{}, error) {
+	decoded := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("value for %q is not a string", k)
+		}
+		runes := []rune(s)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		decoded[k] = string(runes)
+	}
+	return decoded, nil
+}
+
+func TestDifferDecryptsMergedSecretBeforeMasking(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+	diff.SecretDecoders = map[string]SecretDecoder{"reverse": reverseDecoder{}}
+
+	obj := FakeObject{
+		name: "bla",
+		live: map[string]interface{}{
+			"data": map[string]interface{}{"password": "123"},
+		},
+		merged: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{decoderAnnotation: "reverse"},
+			},
+			// ciphertext, i.e. reversed plaintext: differs from the live
+			// "123" byte-for-byte, but decodes to the same plaintext.
+			"data": map[string]interface{}{"password": "321"},
+		},
+	}
+	if err := diff.Diff(context.Background(), &obj, Printer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fcontent, err := ioutil.ReadFile(path.Join(diff.To.Dir.Name, obj.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "data:\n  password: '***'\nmetadata:\n  annotations:\n    diff.kubectl.kubernetes.io/decoder: reverse\n"
+	if string(fcontent) != want {
+		t.Fatalf("got %q, want %q (ciphertext differed from live, but plaintext matched, so no diff markers should appear)", string(fcontent), want)
+	}
+}
+
+func TestDecryptMergedUnknownDecoder(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{decoderAnnotation: "does-not-exist"},
+		},
+		"data": map[string]interface{}{"password": "123"},
+	}}
+	_, err := decryptMerged(obj, defaultSecretDecoders)
+	if err == nil {
+		t.Fatal("expected an error for an unregistered decoder annotation, got nil")
+	}
+}
+
+func TestDecryptMergedNoDataField(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": int64(3)},
+	}}
+	got, err := decryptMerged(obj, defaultSecretDecoders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != obj {
+		t.Fatalf("expected the object to be returned unchanged when it has no data field")
+	}
+}
+
+func TestPlaintextDecoder(t *testing.T) {
+	data := map[string]interface{}{"password": "123"}
+	got, err := PlaintextDecoder{}.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("got %v, want %v", got, data)
+	}
+}
+
+func TestSealedSecretsDecoderRequiresPrivateKey(t *testing.T) {
+	_, err := SealedSecretsDecoder{}.Decode(map[string]interface{}{"password": "abc"})
+	if err == nil {
+		t.Fatal("expected an error without a private key, got nil")
+	}
+}
+
+func TestSealedSecretsDecoderRoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := hybridEncryptForTest(&key.PublicKey, []byte("hunter2"), []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := SealedSecretsDecoder{PrivateKey: key}
+	got, err := decoder.Decode(map[string]interface{}{
+		"password": base64.StdEncoding.EncodeToString(ciphertext),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["password"] != "hunter2" {
+		t.Errorf("got %q, want %q", got["password"], "hunter2")
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"sigs.k8s.io/yaml"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// StructuredFormat selects the machine-readable representation
+// StructuredPrinter produces, driven by the command layer's
+// --output=json|yaml|jsonpatch flag.
+type StructuredFormat string
+
+const (
+	StructuredFormatJSON      StructuredFormat = "json"
+	StructuredFormatYAML      StructuredFormat = "yaml"
+	StructuredFormatJSONPatch StructuredFormat = "jsonpatch"
+)
+
+// objectOperation describes how an object's merged state compares to its
+// live state, for the purposes of structured output. Distinct from
+// DriftOperation (used by Watch), which compares live state across time
+// rather than live against merged.
+type objectOperation string
+
+const (
+	opAdd    objectOperation = "add"
+	opRemove objectOperation = "remove"
+	opModify objectOperation = "modify"
+)
+
+// ObjectDiff is the structured representation of one object's drift, as
+// produced by Differ.DiffStructured for --output=json|yaml.
+type ObjectDiff struct {
+	GVK       schema.GroupVersionKind `json:"gvk"`
+	Namespace string                  `json:"namespace,omitempty"`
+	Name      string                  `json:"name"`
+	Operation objectOperation         `json:"operation"`
+	Changes   []FieldChange           `json:"changes,omitempty"`
+}
+
+// StructuredPrinter renders a single object's diff as JSON, YAML, or a
+// JSON patch instead of writing LIVE/MERGED files out for an external diff
+// binary. Since there's no unified diff text for it to show, the command
+// layer (not present in this package) is expected to call
+// StructuredPrinter.PrintDiff per object in place of Differ.Diff, and skip
+// DiffProgram.Run entirely, whenever --output is set.
+type StructuredPrinter struct {
+	Format StructuredFormat
+	Out    io.Writer
+}
+
+// PrintDiff computes obj's diff against d and writes it to p.Out in
+// p.Format. It writes nothing when there is no drift to report, and, for
+// jsonpatch, nothing when either the live or the merged side is missing.
+func (p StructuredPrinter) PrintDiff(d *Differ, obj Object) error {
+	switch p.Format {
+	case StructuredFormatJSON, StructuredFormatYAML:
+		diff, err := d.DiffStructured(obj)
+		if err != nil {
+			return err
+		}
+		if diff == nil {
+			return nil
+		}
+		return p.writeObjectDiff(*diff)
+	case StructuredFormatJSONPatch:
+		patch, err := d.JSONPatch(obj)
+		if err != nil {
+			return err
+		}
+		if len(patch) == 0 || string(patch) == "{}" {
+			return nil
+		}
+		_, err = p.Out.Write(append(patch, '\n'))
+		return err
+	default:
+		return fmt.Errorf("unknown structured output format: %q", p.Format)
+	}
+}
+
+func (p StructuredPrinter) writeObjectDiff(diff ObjectDiff) error {
+	var (
+		data []byte
+		err  error
+	)
+	switch p.Format {
+	case StructuredFormatJSON:
+		data, err = json.Marshal(diff)
+	case StructuredFormatYAML:
+		data, err = yaml.Marshal(diff)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = p.Out.Write(append(data, '\n'))
+	return err
+}
+
+// DiffStructured computes obj's live-vs-merged diff as an ObjectDiff,
+// applying the same decrypt-then-mask pipeline as Diff. It returns nil
+// (with no error) when the object is unchanged. Unlike Diff, it does not
+// fan out across d.Contexts: structured output always reflects the
+// default/current cluster context.
+func (d *Differ) DiffStructured(obj Object) (*ObjectDiff, error) {
+	diff, _, _, err := d.diffStructured(obj)
+	return diff, err
+}
+
+// diffStructured is DiffStructured's implementation, additionally
+// returning the decoded live/merged trees so CheckPolicy can build a
+// PolicyInput without recomputing them.
+func (d *Differ) diffStructured(obj Object) (diff *ObjectDiff, fromData, toData map[string]interface{}, err error) {
+	decoders := d.SecretDecoders
+	if decoders == nil {
+		decoders = defaultSecretDecoders
+	}
+
+	from, to, err := d.liveAndMerged(obj, decoders)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	fromData, err = toMap(from)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	toData, err = toMap(to)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return buildObjectDiff(obj, fromData, toData), fromData, toData, nil
+}
+
+// buildObjectDiff compares the decoded live (fromData) and merged (toData)
+// trees of obj and returns the resulting ObjectDiff, or nil if they are
+// equivalent. Shared by diffStructured and Differ.Diff, so --output=json|yaml
+// and --policy-bundle gating see exactly the same drift Diff itself prints.
+func buildObjectDiff(obj Object, fromData, toData map[string]interface{}) *ObjectDiff {
+	diff := &ObjectDiff{
+		GVK:       obj.GroupVersionKind(),
+		Name:      obj.Name(),
+		Namespace: obj.Namespace(),
+	}
+	switch {
+	case fromData == nil && toData == nil:
+		return nil
+	case fromData == nil:
+		diff.Operation = opAdd
+	case toData == nil:
+		diff.Operation = opRemove
+	default:
+		diff.Operation = opModify
+		diff.Changes = diffFields("", fromData, toData)
+		if len(diff.Changes) == 0 {
+			return nil
+		}
+	}
+	return diff
+}
+
+// JSONPatch computes a patch from obj's live state to its merged state
+// using jsonpatch.CreateMergePatch. Despite the package name, that
+// produces an RFC 7386 JSON Merge Patch rather than an RFC 6902 JSON
+// Patch; the result is returned as-is so callers get whatever
+// --output=jsonpatch was asked to produce. Returns nil when either side
+// is missing, since there's nothing to patch from or to.
+func (d *Differ) JSONPatch(obj Object) ([]byte, error) {
+	decoders := d.SecretDecoders
+	if decoders == nil {
+		decoders = defaultSecretDecoders
+	}
+
+	from, to, err := d.liveAndMerged(obj, decoders)
+	if err != nil {
+		return nil, err
+	}
+	if from == nil || to == nil {
+		return nil, nil
+	}
+
+	fromRaw, err := json.Marshal(from)
+	if err != nil {
+		return nil, err
+	}
+	toRaw, err := json.Marshal(to)
+	if err != nil {
+		return nil, err
+	}
+	return jsonpatch.CreateMergePatch(fromRaw, toRaw)
+}
+
+// liveAndMerged fetches obj's default-context live and merged objects and
+// runs them through the same decrypt-then-mask pipeline Diff uses.
+func (d *Differ) liveAndMerged(obj Object, decoders map[string]SecretDecoder) (from, to runtime.Object, err error) {
+	from, err = d.From.getObject(obj, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err = d.To.getObject(obj, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err = decryptMerged(to, decoders)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	masker := Masker{IgnorePaths: d.IgnorePaths}
+	from, err = masker.Filter(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err = masker.Filter(to)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mask(from, to)
+}
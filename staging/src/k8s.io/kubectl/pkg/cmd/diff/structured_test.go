@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDiffStructuredModify(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	obj := FakeObject{
+		name:      "bla",
+		namespace: "default",
+		group:     "apps",
+		version:   "v1",
+		kind:      "Deployment",
+		live: map[string]interface{}{
+			"spec": map[string]interface{}{"replicas": int64(1)},
+		},
+		merged: map[string]interface{}{
+			"spec": map[string]interface{}{"replicas": int64(3)},
+		},
+	}
+
+	got, err := diff.DiffStructured(&obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Fatal("expected a non-nil ObjectDiff for a changed object")
+	}
+	if got.Operation != opModify {
+		t.Errorf("got operation %q, want %q", got.Operation, opModify)
+	}
+	if got.Namespace != "default" {
+		t.Errorf("got namespace %q, want %q", got.Namespace, "default")
+	}
+	if len(got.Changes) != 1 || got.Changes[0].Path != "/spec/replicas" {
+		t.Errorf("got changes %+v, want a single change at /spec/replicas", got.Changes)
+	}
+}
+
+func TestDiffStructuredAddAndRemove(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	added := FakeObject{name: "new", merged: map[string]interface{}{"spec": "x"}}
+	got, err := diff.DiffStructured(&added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Operation != opAdd {
+		t.Fatalf("got %+v, want operation %q", got, opAdd)
+	}
+
+	removed := FakeObject{name: "gone", live: map[string]interface{}{"spec": "x"}}
+	got, err = diff.DiffStructured(&removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Operation != opRemove {
+		t.Fatalf("got %+v, want operation %q", got, opRemove)
+	}
+}
+
+func TestDiffStructuredUnchanged(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	obj := FakeObject{
+		name:   "bla",
+		live:   map[string]interface{}{"spec": "same"},
+		merged: map[string]interface{}{"spec": "same"},
+	}
+	got, err := diff.DiffStructured(&obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Fatalf("got %+v, want nil for an unchanged object", got)
+	}
+}
+
+func TestStructuredPrinterJSON(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	obj := FakeObject{
+		name:   "bla",
+		live:   map[string]interface{}{"spec": int64(1)},
+		merged: map[string]interface{}{"spec": int64(2)},
+	}
+
+	var buf bytes.Buffer
+	printer := StructuredPrinter{Format: StructuredFormatJSON, Out: &buf}
+	if err := printer.PrintDiff(diff, &obj); err != nil {
+		t.Fatal(err)
+	}
+
+	var got ObjectDiff
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output %q did not decode as an ObjectDiff: %v", buf.String(), err)
+	}
+	if got.Name != "bla" || got.Operation != opModify {
+		t.Errorf("got %+v, want name %q operation %q", got, "bla", opModify)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	obj := FakeObject{
+		name:   "bla",
+		live:   map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}},
+		merged: map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}},
+	}
+
+	patch, err := diff.JSONPatch(&obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(patch, &decoded); err != nil {
+		t.Fatalf("patch %q did not decode as JSON: %v", patch, err)
+	}
+	spec, ok := decoded["spec"].(map[string]interface{})
+	if !ok || spec["replicas"] != float64(3) {
+		t.Errorf("got patch %s, want it to set spec.replicas to 3", patch)
+	}
+}
+
+func TestJSONPatchMissingSide(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	obj := FakeObject{name: "new", merged: map[string]interface{}{"spec": "x"}}
+	patch, err := diff.JSONPatch(&obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch != nil {
+		t.Errorf("got %q, want nil patch when the live side is missing", patch)
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/klog/v2"
+)
+
+// DriftOperation describes how an observed object changed between two
+// consecutive Watch ticks.
+type DriftOperation string
+
+const (
+	DriftAdded    DriftOperation = "added"
+	DriftRemoved  DriftOperation = "removed"
+	DriftModified DriftOperation = "modified"
+)
+
+// FieldChange describes a single field that changed between two versions
+// of an object (two Watch ticks, or live and merged in an ObjectDiff),
+// addressed by JSON pointer (RFC 6901) relative to the object root.
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// DriftEvent is emitted whenever Watch detects that the live state of an
+// object has deviated from what was last observed.
+type DriftEvent struct {
+	Context   string
+	Namespace string
+	Name      string
+	GVK       schema.GroupVersionKind
+	Operation DriftOperation
+	Changes   []FieldChange
+}
+
+// WatchOptions configures a continuous drift-detection run.
+type WatchOptions struct {
+	// Interval is how often the watched objects are re-fetched. Defaults
+	// to 30s.
+	Interval time.Duration
+	// OnEvent is called for every detected drift event. If nil, events
+	// are written to streams.Out instead: a one-line summary, followed by
+	// one line per changed field.
+	OnEvent func(DriftEvent)
+	// ExitOnDrift makes Watch return as soon as the first drift is
+	// observed, for use in CI.
+	ExitOnDrift bool
+	// CacheSize bounds how many objects' last-seen state Watch keeps in
+	// memory at once. Defaults to defaultWatchCacheSize.
+	CacheSize int
+}
+
+// resourceKey identifies a single object across ticks. Namespace is
+// required alongside GVK+name: two distinct namespaced objects (e.g. a
+// ConfigMap/app-config in "staging" and one in "prod") would otherwise
+// collide in watchCache, with one namespace's live state silently
+// clobbering the other's.
+type resourceKey struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+}
+
+// defaultWatchCacheSize bounds how many objects' last-seen state Watch
+// keeps in memory when WatchOptions.CacheSize isn't set, so a runaway
+// resource list can't grow the process without limit.
+const defaultWatchCacheSize = 10000
+
+// watchCache is a fixed-size, insertion-ordered cache of last-seen object
+// state keyed by resourceKey. Inserting past maxSize evicts the oldest
+// entry first.
+type watchCache struct {
+	maxSize int
+	order   []resourceKey
+	data    map[resourceKey]map[string]interface{}
+}
+
+func newWatchCache(maxSize int) *watchCache {
+	if maxSize <= 0 {
+		maxSize = defaultWatchCacheSize
+	}
+	return &watchCache{maxSize: maxSize, data: map[resourceKey]map[string]interface{}{}}
+}
+
+func (c *watchCache) get(key resourceKey) (map[string]interface{}, bool) {
+	v, ok := c.data[key]
+	return v, ok
+}
+
+func (c *watchCache) set(key resourceKey, value map[string]interface{}) {
+	if _, exists := c.data[key]; !exists {
+		if len(c.order) >= c.maxSize {
+			oldest := c.order[0]
+			c.order = c.order[1:]
+			delete(c.data, oldest)
+		}
+		c.order = append(c.order, key)
+	}
+	c.data[key] = value
+}
+
+func (c *watchCache) delete(key resourceKey) {
+	if _, exists := c.data[key]; !exists {
+		return
+	}
+	delete(c.data, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// keys returns the cached keys in insertion order, safe to range over
+// while mutating the cache.
+func (c *watchCache) keys() []resourceKey {
+	keys := make([]resourceKey, len(c.order))
+	copy(keys, c.order)
+	return keys
+}
+
+// Watch periodically calls fetch to re-list the objects under
+// observation, diffs each one's live state against what was last seen, and
+// reports drift through opts.OnEvent (or streams.Out). The cache of
+// last-seen state is capped at opts.CacheSize entries, evicting the oldest
+// first; objects that stop being returned by fetch (or whose Live() turns
+// nil) are reported as DriftRemoved and evicted immediately. Watch keeps
+// running until ctx is canceled, fetch keeps failing past the retry
+// budget, or (with ExitOnDrift) the first drift is observed.
+func (d *Differ) Watch(ctx context.Context, streams genericclioptions.IOStreams, fetch func(context.Context) ([]Object, error), opts WatchOptions) error {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	backoff := wait.Backoff{
+		Duration: time.Second,
+		Factor:   2,
+		Steps:    5,
+		Cap:      opts.Interval,
+	}
+
+	cache := newWatchCache(opts.CacheSize)
+	for {
+		var objs []Object
+		err := wait.ExponentialBackoffWithContext(ctx, backoff, func(ctx context.Context) (bool, error) {
+			var ferr error
+			objs, ferr = fetch(ctx)
+			if ferr != nil {
+				klog.V(2).Infof("diff watch: fetch failed, retrying: %v", ferr)
+				return false, nil
+			}
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		seen := map[resourceKey]bool{}
+		for _, obj := range objs {
+			key := resourceKey{gvk: obj.GroupVersionKind(), namespace: obj.Namespace(), name: obj.Name()}
+			seen[key] = true
+
+			data, err := toMap(obj.Live(""))
+			if err != nil {
+				return err
+			}
+
+			prev, known := cache.get(key)
+			var event *DriftEvent
+			switch {
+			case !known && data != nil:
+				event = &DriftEvent{Namespace: key.namespace, Name: obj.Name(), GVK: key.gvk, Operation: DriftAdded}
+			case known && data == nil:
+				event = &DriftEvent{Namespace: key.namespace, Name: obj.Name(), GVK: key.gvk, Operation: DriftRemoved}
+			case known && data != nil:
+				if changes := diffFields("", prev, data); len(changes) > 0 {
+					event = &DriftEvent{Namespace: key.namespace, Name: obj.Name(), GVK: key.gvk, Operation: DriftModified, Changes: changes}
+				}
+			}
+
+			if data == nil {
+				cache.delete(key)
+			} else {
+				cache.set(key, data)
+			}
+
+			if event == nil {
+				continue
+			}
+			d.emitDrift(streams, opts, *event)
+			if opts.ExitOnDrift {
+				return fmt.Errorf("drift detected: %s %s/%s", event.Operation, event.GVK.Kind, event.Name)
+			}
+		}
+		// Anything still cached but no longer returned by fetch has been
+		// deleted outright (as opposed to still being listed with a nil
+		// Live()): report it the same way as an in-place removal.
+		for _, key := range cache.keys() {
+			if seen[key] {
+				continue
+			}
+			event := DriftEvent{Namespace: key.namespace, Name: key.name, GVK: key.gvk, Operation: DriftRemoved}
+			cache.delete(key)
+			d.emitDrift(streams, opts, event)
+			if opts.ExitOnDrift {
+				return fmt.Errorf("drift detected: %s %s/%s", event.Operation, event.GVK.Kind, event.Name)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+func (d *Differ) emitDrift(streams genericclioptions.IOStreams, opts WatchOptions, event DriftEvent) {
+	if opts.OnEvent != nil {
+		opts.OnEvent(event)
+		return
+	}
+	fmt.Fprintf(streams.Out, "%s %s/%s\n", event.Operation, event.GVK.Kind, event.Name)
+	for _, change := range event.Changes {
+		fmt.Fprintf(streams.Out, "  %s: %v -> %v\n", change.Path, change.Before, change.After)
+	}
+}
+
+// toMap returns the Unstructured content of obj, or nil if obj is nil.
+func toMap(obj runtime.Object) (map[string]interface{}, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("object %T is not unstructured", obj)
+	}
+	return u.Object, nil
+}
+
+// diffFields recursively compares two decoded object trees and returns the
+// set of changes, addressed by JSON pointer relative to base.
+func diffFields(base string, before, after interface{}) []FieldChange {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		a, ok := after.(map[string]interface{})
+		if !ok {
+			if reflect.DeepEqual(before, after) {
+				return nil
+			}
+			return []FieldChange{{Path: base, Before: before, After: after}}
+		}
+		keys := map[string]bool{}
+		for k := range b {
+			keys[k] = true
+		}
+		for k := range a {
+			keys[k] = true
+		}
+		var changes []FieldChange
+		for k := range keys {
+			changes = append(changes, diffFields(base+"/"+escapeJSONPointerToken(k), b[k], a[k])...)
+		}
+		return changes
+	case []interface{}:
+		a, ok := after.([]interface{})
+		if !ok || len(a) != len(b) {
+			if reflect.DeepEqual(before, after) {
+				return nil
+			}
+			return []FieldChange{{Path: base, Before: before, After: after}}
+		}
+		var changes []FieldChange
+		for i := range b {
+			changes = append(changes, diffFields(fmt.Sprintf("%s/%d", base, i), b[i], a[i])...)
+		}
+		return changes
+	default:
+		if reflect.DeepEqual(before, after) {
+			return nil
+		}
+		return []FieldChange{{Path: base, Before: before, After: after}}
+	}
+}
+
+// escapeJSONPointerToken escapes a map key per RFC 6901 so it can be used
+// as a JSON pointer reference token.
+func escapeJSONPointerToken(token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	token = strings.ReplaceAll(token, "/", "~1")
+	return token
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestDifferWatch(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	streams, _, _, _ := genericclioptions.NewTestIOStreams()
+
+	tick := 0
+	fetch := func(context.Context) ([]Object, error) {
+		tick++
+		switch tick {
+		case 1:
+			return []Object{&FakeObject{name: "bla", live: map[string]interface{}{"replicas": int64(1)}}}, nil
+		case 2:
+			return []Object{&FakeObject{name: "bla", live: map[string]interface{}{"replicas": int64(3)}}}, nil
+		default:
+			return nil, nil
+		}
+	}
+
+	var events []DriftEvent
+	ctx, cancel := context.WithCancel(context.Background())
+	err = diff.Watch(ctx, streams, fetch, WatchOptions{
+		Interval: time.Millisecond,
+		OnEvent: func(e DriftEvent) {
+			events = append(events, e)
+			if len(events) == 3 {
+				cancel()
+			}
+		},
+	})
+	if err != nil && err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(events), events)
+	}
+	if events[0].Operation != DriftAdded {
+		t.Errorf("event 0: got operation %q, want %q", events[0].Operation, DriftAdded)
+	}
+	if events[1].Operation != DriftModified {
+		t.Errorf("event 1: got operation %q, want %q", events[1].Operation, DriftModified)
+	}
+	if len(events[1].Changes) != 1 || events[1].Changes[0].Path != "/replicas" {
+		t.Errorf("event 1: got changes %+v, want a single change at /replicas", events[1].Changes)
+	}
+	if events[2].Operation != DriftRemoved {
+		t.Errorf("event 2: got operation %q, want %q", events[2].Operation, DriftRemoved)
+	}
+}
+
+// TestDifferWatchNamespaceCollision guards against resourceKey colliding
+// on GVK+name alone: two distinct namespaces each have their own
+// ConfigMap/app-config, one of which changes while the other stays put,
+// and only the one that actually changed should be reported.
+func TestDifferWatchNamespaceCollision(t *testing.T) {
+	diff, err := NewDiffer("LIVE", "MERGED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.TearDown()
+
+	streams, _, _, _ := genericclioptions.NewTestIOStreams()
+
+	tick := 0
+	fetch := func(context.Context) ([]Object, error) {
+		tick++
+		staging := &FakeObject{name: "app-config", namespace: "staging", live: map[string]interface{}{"key": "same"}}
+		switch tick {
+		case 1:
+			return []Object{
+				staging,
+				&FakeObject{name: "app-config", namespace: "prod", live: map[string]interface{}{"key": "v1"}},
+			}, nil
+		default:
+			return []Object{
+				staging,
+				&FakeObject{name: "app-config", namespace: "prod", live: map[string]interface{}{"key": "v2"}},
+			}, nil
+		}
+	}
+
+	var events []DriftEvent
+	ctx, cancel := context.WithCancel(context.Background())
+	err = diff.Watch(ctx, streams, fetch, WatchOptions{
+		Interval: time.Millisecond,
+		OnEvent: func(e DriftEvent) {
+			events = append(events, e)
+			if len(events) == 3 {
+				cancel()
+			}
+		},
+	})
+	if err != nil && err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3 (2 added, 1 modified): %+v", len(events), events)
+	}
+	if events[2].Namespace != "prod" {
+		t.Errorf("got the modified event for namespace %q, want %q (staging's unchanged ConfigMap must not report drift)", events[2].Namespace, "prod")
+	}
+	if events[2].Operation != DriftModified {
+		t.Errorf("got operation %q, want %q", events[2].Operation, DriftModified)
+	}
+}
+
+func TestDiffFields(t *testing.T) {
+	cases := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+		want   []string
+	}{
+		{
+			name:   "identical",
+			before: map[string]interface{}{"a": "1"},
+			after:  map[string]interface{}{"a": "1"},
+			want:   nil,
+		},
+		{
+			name:   "changed_value",
+			before: map[string]interface{}{"a": "1"},
+			after:  map[string]interface{}{"a": "2"},
+			want:   []string{"/a"},
+		},
+		{
+			name:   "nested_map",
+			before: map[string]interface{}{"a": map[string]interface{}{"b": "1"}},
+			after:  map[string]interface{}{"a": map[string]interface{}{"b": "2"}},
+			want:   []string{"/a/b"},
+		},
+		{
+			name:   "array_element",
+			before: map[string]interface{}{"a": []interface{}{"1", "2"}},
+			after:  map[string]interface{}{"a": []interface{}{"1", "3"}},
+			want:   []string{"/a/1"},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			changes := diffFields("", tc.before, tc.after)
+			if len(changes) != len(tc.want) {
+				t.Fatalf("got %d changes, want %d: %+v", len(changes), len(tc.want), changes)
+			}
+			got := map[string]bool{}
+			for _, c := range changes {
+				got[c.Path] = true
+			}
+			for _, path := range tc.want {
+				if !got[path] {
+					t.Errorf("missing expected change at %q, got %+v", path, changes)
+				}
+			}
+		})
+	}
+}